@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/gorilla/mux"
+)
+
+func main() {
+	signupConfig := NewSignupConfig()
+	signupRouter := NewSignupRouter(signupConfig, NewSsoServices())
+
+	router := mux.NewRouter()
+	signupRouter.setupRoutes(router.PathPrefix("/signup").Subrouter())
+
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(RequireAuth)
+	signupRouter.setupAdminRoutes(adminRouter)
+
+	stopSweeper := make(chan struct{})
+	go StartSignupSweeper(signupConfig.SignupTTL, signupConfig.SweepInterval, stopSweeper)
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: router,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+	close(stopSweeper)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// SignupConfig holds the runtime configuration for the public signup flow,
+// allowing self-hosters to point it at their own hosting domain and mail
+// sender instead of the public seatsurfing.de defaults.
+type SignupConfig struct {
+	// BaseDomain is appended to the requested subdomain to form the
+	// tenant's default domain, e.g. "<domain>.<BaseDomain>".
+	BaseDomain string
+	// SenderAddress is used as the from-address for double opt-in and
+	// confirmation mails sent by the signup router.
+	SenderAddress string
+	// AllowedCustomDomains controls whether organizations may add a
+	// custom domain via POST /admin/domain (registered by setupAdminRoutes
+	// on the authenticated admin router, not under /signup).
+	AllowedCustomDomains bool
+	// RequireDNSVerification controls whether a custom domain must pass
+	// the TXT record ownership challenge before it is added.
+	RequireDNSVerification bool
+	// RateLimitEnabled enables the per-IP and per-email-domain token
+	// bucket rate limiter on the public signup endpoint.
+	RateLimitEnabled bool
+	// RateLimitRPS and RateLimitBurst configure the per-IP token bucket.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// RateLimitDomainRPS and RateLimitDomainBurst configure the
+	// per-email-domain token bucket separately from the per-IP one.
+	// Email domains are shared across many unrelated signups (gmail.com,
+	// outlook.com, ...), so this bucket needs a much higher bound than
+	// the per-IP one or legitimate signups from common free-mail
+	// providers would be rejected after only a handful of unrelated
+	// signups anywhere in the rate limit window.
+	RateLimitDomainRPS   float64
+	RateLimitDomainBurst int
+	// CaptchaEnabled requires a valid CAPTCHA token on every signup.
+	CaptchaEnabled bool
+	// CaptchaProvider selects the verifier: "hcaptcha", "recaptcha" or
+	// "turnstile".
+	CaptchaProvider string
+	CaptchaSecret   string
+	// EmailDomainPolicyEnabled enables the allow/deny-list and MX record
+	// checks on the signup email's domain.
+	EmailDomainPolicyEnabled bool
+	// AllowedEmailDomains and DeniedEmailDomains are compared
+	// case-insensitively against the signup email's domain. "*" in
+	// AllowedEmailDomains means any domain is allowed.
+	AllowedEmailDomains []string
+	DeniedEmailDomains  []string
+	// RequireMXRecord rejects signups whose email domain has no MX
+	// record.
+	RequireMXRecord bool
+	// SignupTTL is how long an unconfirmed Signup row is kept before the
+	// sweeper deletes it.
+	SignupTTL time.Duration
+	// SweepInterval is how often the sweeper checks for expired Signup
+	// rows.
+	SweepInterval time.Duration
+	// RequireManualApproval makes confirm() and the SSO callback only mark
+	// a Signup as email-verified; a superadmin must then approve it via
+	// POST /admin/signups/{id}/approve before the organization is created.
+	RequireManualApproval bool
+}
+
+// NewSignupConfig reads the signup configuration from the environment,
+// falling back to the public seatsurfing.de hosting defaults. If
+// SIGNUP_SUPPORTED_LANGUAGES or SIGNUP_SUPPORTED_COUNTRIES are set, they
+// override the package-level SupportedLanguages/SupportedCountries used
+// for payload validation and email template negotiation.
+func NewSignupConfig() *SignupConfig {
+	if value, ok := os.LookupEnv("SIGNUP_SUPPORTED_LANGUAGES"); ok {
+		SetSupportedLanguages(parseLanguageTags(value))
+	}
+	if value, ok := os.LookupEnv("SIGNUP_SUPPORTED_COUNTRIES"); ok {
+		SetSupportedCountries(mustParseRegions(strings.Split(value, ",")))
+	}
+	return &SignupConfig{
+		BaseDomain:               getEnv("SIGNUP_BASE_DOMAIN", "on.seatsurfing.de"),
+		SenderAddress:            getEnv("SIGNUP_SENDER_ADDRESS", "info@seatsurfing.de"),
+		AllowedCustomDomains:     getEnvBool("SIGNUP_ALLOW_CUSTOM_DOMAINS", false),
+		RequireDNSVerification:   getEnvBool("SIGNUP_REQUIRE_DNS_VERIFICATION", true),
+		RateLimitEnabled:         getEnvBool("SIGNUP_RATE_LIMIT_ENABLED", true),
+		RateLimitRPS:             getEnvFloat("SIGNUP_RATE_LIMIT_RPS", 0.1),
+		RateLimitBurst:           getEnvInt("SIGNUP_RATE_LIMIT_BURST", 3),
+		RateLimitDomainRPS:       getEnvFloat("SIGNUP_RATE_LIMIT_DOMAIN_RPS", 1),
+		RateLimitDomainBurst:     getEnvInt("SIGNUP_RATE_LIMIT_DOMAIN_BURST", 30),
+		CaptchaEnabled:           getEnvBool("SIGNUP_CAPTCHA_ENABLED", false),
+		CaptchaProvider:          getEnv("SIGNUP_CAPTCHA_PROVIDER", ""),
+		CaptchaSecret:            getEnv("SIGNUP_CAPTCHA_SECRET", ""),
+		EmailDomainPolicyEnabled: getEnvBool("SIGNUP_EMAIL_DOMAIN_POLICY_ENABLED", false),
+		AllowedEmailDomains:      getEnvList("SIGNUP_ALLOWED_EMAIL_DOMAINS", []string{"*"}),
+		DeniedEmailDomains:       getEnvList("SIGNUP_DENIED_EMAIL_DOMAINS", []string{}),
+		RequireMXRecord:          getEnvBool("SIGNUP_REQUIRE_MX_RECORD", false),
+		SignupTTL:                getEnvDuration("SIGNUP_TTL", 72*time.Hour),
+		SweepInterval:            getEnvDuration("SIGNUP_SWEEP_INTERVAL", 1*time.Hour),
+		RequireManualApproval:    getEnvBool("SIGNUP_REQUIRE_MANUAL_APPROVAL", false),
+	}
+}
+
+func parseLanguageTags(commaSeparated string) []language.Tag {
+	codes := strings.Split(commaSeparated, ",")
+	tags := make([]language.Tag, 0, len(codes))
+	for _, code := range codes {
+		if tag, err := language.Parse(strings.TrimSpace(code)); err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	parts := strings.Split(value, ",")
+	list := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			list = append(list, trimmed)
+		}
+	}
+	return list
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// EmailDomainPolicy allow/deny-lists the domains accepted for signup,
+// mirroring the AuthenticatedEmailsFile convention used by oauth2_proxy:
+// "*" in AllowedDomains means any domain is allowed.
+type EmailDomainPolicy struct {
+	AllowedDomains []string
+	DeniedDomains  []string
+	RequireMX      bool
+}
+
+// IsAllowed reports whether email's domain passes the allow/deny-list and,
+// if RequireMX is set, has at least one MX record.
+func (p *EmailDomainPolicy) IsAllowed(email string) bool {
+	domain := emailDomain(email)
+	if domain == "" {
+		return false
+	}
+	if containsDomain(p.DeniedDomains, domain) {
+		return false
+	}
+	if len(p.AllowedDomains) > 0 && !containsDomain(p.AllowedDomains, "*") && !containsDomain(p.AllowedDomains, domain) {
+		return false
+	}
+	if p.RequireMX && !hasMXRecord(domain) {
+		return false
+	}
+	return true
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMXRecord(domain string) bool {
+	records, err := net.LookupMX(domain)
+	return err == nil && len(records) > 0
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// SupportedLanguages is the set of languages the signup flow accepts for
+// its `language` payload field and negotiates against when picking an
+// email template. It defaults to English and German and can be widened
+// at startup via SetSupportedLanguages. English must stay first: the
+// language.Matcher built from this slice treats its first tag as the
+// fallback for a low-confidence (i.e. unsupported) Accept-Language, and
+// English is the intended international default rather than German.
+var SupportedLanguages = []language.Tag{
+	language.English,
+	language.German,
+}
+
+// SupportedCountries restricts the `country` field accepted by the signup
+// payload to an allow-list of ISO 3166-1 alpha-2 regions. An empty list
+// means any valid ISO region code is accepted. Defaults to the EU member
+// states and can be widened at startup via SetSupportedCountries.
+var SupportedCountries = mustParseRegions([]string{
+	"BE", "BG", "DK", "DE", "EE", "FI", "FR", "GR", "IE", "IT", "HR", "LV",
+	"LT", "LU", "MT", "NL", "AT", "PL", "PT", "RO", "SE", "SK", "SI", "ES",
+	"CZ", "HU", "CY",
+})
+
+var languageMatcher = language.NewMatcher(SupportedLanguages)
+
+// SetSupportedLanguages overrides SupportedLanguages and rebuilds the
+// matcher used by getLanguage. Intended to be called once at startup.
+func SetSupportedLanguages(tags []language.Tag) {
+	SupportedLanguages = tags
+	languageMatcher = language.NewMatcher(SupportedLanguages)
+}
+
+// SetSupportedCountries overrides SupportedCountries. Intended to be
+// called once at startup.
+func SetSupportedCountries(regions []language.Region) {
+	SupportedCountries = regions
+}
+
+func mustParseRegions(codes []string) []language.Region {
+	regions := make([]language.Region, 0, len(codes))
+	for _, code := range codes {
+		if region, err := language.ParseRegion(strings.TrimSpace(code)); err == nil {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// isValidCountryCode reports whether isoCountryCode is a valid ISO 3166-1
+// region that is also present in SupportedCountries (when that allow-list
+// is non-empty).
+func isValidCountryCode(isoCountryCode string) bool {
+	region, err := language.ParseRegion(isoCountryCode)
+	if err != nil {
+		return false
+	}
+	if len(SupportedCountries) == 0 {
+		return true
+	}
+	for _, supported := range SupportedCountries {
+		if supported == region {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidLanguageCode reports whether isoLanguageCode parses as a BCP 47
+// language tag whose base language is in SupportedLanguages.
+func isValidLanguageCode(isoLanguageCode string) bool {
+	tag, err := language.Parse(isoLanguageCode)
+	if err != nil {
+		return false
+	}
+	base, _ := tag.Base()
+	for _, supported := range SupportedLanguages {
+		supportedBase, _ := supported.Base()
+		if base == supportedBase {
+			return true
+		}
+	}
+	return false
+}
+
+// getLanguage negotiates the best-matching supported language for a
+// request using golang.org/x/text/language. It prefers the Accept-Language
+// header; the signup payload's own language is only used as the
+// negotiation input when the header is absent or fails to parse as a
+// BCP 47 list. A header that parses but names nothing we support still
+// resolves through the matcher's own closest-match behavior rather than
+// falling back to the payload.
+func getLanguage(r *http.Request, payloadLanguage string) string {
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept-Language")
+	}
+	if strings.TrimSpace(accept) == "" {
+		accept = payloadLanguage
+	}
+	tags, _, err := language.ParseAcceptLanguage(accept)
+	if err != nil || len(tags) == 0 {
+		if tag, parseErr := language.Parse(payloadLanguage); parseErr == nil {
+			tags = []language.Tag{tag}
+		}
+	}
+	tag, _, _ := languageMatcher.Match(tags...)
+	base, _ := tag.Base()
+	return base.String()
+}
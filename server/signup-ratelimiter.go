@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateLimiterEvictInterval = 5 * time.Minute
+	rateLimiterIdleTTL       = 15 * time.Minute
+)
+
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// SignupRateLimiter enforces a token-bucket rate limit per key, so the
+// public signup endpoint can be protected against abuse both per client
+// IP and per email domain. Entries idle for longer than
+// rateLimiterIdleTTL are evicted so a flood of distinct keys cannot grow
+// the underlying map without bound.
+type SignupRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rateLimiterEntry
+	rps      rate.Limit
+	burst    int
+}
+
+// NewSignupRateLimiter creates a rate limiter allowing rps requests per
+// second per key, with bursts up to burst, and starts its background
+// eviction loop.
+func NewSignupRateLimiter(rps float64, burst int) *SignupRateLimiter {
+	l := &SignupRateLimiter{
+		limiters: make(map[string]*rateLimiterEntry),
+		rps:      rate.Limit(rps),
+		burst:    burst,
+	}
+	go l.evictIdleLoop(rateLimiterEvictInterval, rateLimiterIdleTTL)
+	return l
+}
+
+// Allow reports whether a request for the given key is within the rate
+// limit, consuming a token if so.
+func (l *SignupRateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	entry, ok := l.limiters[key]
+	if !ok {
+		entry = &rateLimiterEntry{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.limiters[key] = entry
+	}
+	entry.lastSeen = time.Now()
+	limiter := entry.limiter
+	l.mu.Unlock()
+	return limiter.Allow()
+}
+
+// evictIdleLoop periodically removes limiters that have not been used for
+// idleTTL. Runs for the lifetime of the process.
+func (l *SignupRateLimiter) evictIdleLoop(interval, idleTTL time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleTTL)
+		l.mu.Lock()
+		for key, entry := range l.limiters {
+			if entry.lastSeen.Before(cutoff) {
+				delete(l.limiters, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// getClientIP returns the originating client's RemoteAddr, stripped of
+// its port. X-Forwarded-For is intentionally not trusted here: it is
+// attacker-controlled unless a reverse proxy is configured to strip and
+// re-set it, and keying the rate limiter on it would let an attacker get
+// a fresh bucket on every request just by rotating the header.
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// emailDomain returns the lowercased domain part of email, or "" if email
+// has no domain part.
+func emailDomain(email string) string {
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
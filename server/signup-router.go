@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -10,6 +11,57 @@ import (
 )
 
 type SignupRouter struct {
+	Config            *SignupConfig
+	SsoServices       []SsoConfig
+	RateLimiter       *SignupRateLimiter
+	DomainRateLimiter *SignupRateLimiter
+	Captcha           CaptchaVerifier
+	EmailPolicy       *EmailDomainPolicy
+}
+
+// NewSignupRouter creates a SignupRouter using the given configuration and
+// the list of SSO providers available for federated signup. The rate
+// limiters, CAPTCHA verifier and email domain policy are built from config
+// and left nil (disabled) for anything config turns off, so self-hosters
+// can opt out of each defense independently.
+func NewSignupRouter(config *SignupConfig, ssoServices []SsoConfig) *SignupRouter {
+	router := &SignupRouter{
+		Config:      config,
+		SsoServices: ssoServices,
+	}
+	if config.RateLimitEnabled {
+		router.RateLimiter = NewSignupRateLimiter(config.RateLimitRPS, config.RateLimitBurst)
+		router.DomainRateLimiter = NewSignupRateLimiter(config.RateLimitDomainRPS, config.RateLimitDomainBurst)
+	}
+	if config.CaptchaEnabled {
+		verifier, err := NewCaptchaVerifier(config.CaptchaProvider, config.CaptchaSecret)
+		if err != nil {
+			log.Fatalf("signup: CAPTCHA is enabled but misconfigured: %v", err)
+		}
+		router.Captcha = verifier
+	}
+	if config.EmailDomainPolicyEnabled {
+		router.EmailPolicy = &EmailDomainPolicy{
+			AllowedDomains: config.AllowedEmailDomains,
+			DeniedDomains:  config.DeniedEmailDomains,
+			RequireMX:      config.RequireMXRecord,
+		}
+	}
+	return router
+}
+
+type CustomDomainRequest struct {
+	Domain string `json:"domain" validate:"required"`
+}
+
+type CustomDomainChallengeResponse struct {
+	Verified    bool   `json:"verified"`
+	RecordName  string `json:"recordName"`
+	RecordValue string `json:"recordValue"`
+}
+
+type ResendRequest struct {
+	Email string `json:"email" validate:"required,email"`
 }
 
 type SignupRequest struct {
@@ -24,13 +76,31 @@ type SignupRequest struct {
 	Country           string `json:"country" validate:"required,len=2"`
 	Language          string `json:"language" validate:"required,len=2"`
 	AcceptTerms       bool   `json:"acceptTerms" validate:"required"`
+	CaptchaToken      string `json:"captchaToken"`
 }
 
 func (router *SignupRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/confirm/{id}", router.confirm).Methods("POST")
+	s.HandleFunc("/sso/{provider}", router.startSsoSignup).Methods("POST")
+	s.HandleFunc("/sso/{provider}/callback", router.ssoSignupCallback).Methods("GET")
+	s.HandleFunc("/resend", router.resend).Methods("POST")
 	s.HandleFunc("/", router.signup).Methods("POST")
 }
 
+// setupAdminRoutes registers the signup endpoints that require an
+// authenticated caller: the superadmin-only approval endpoints used by
+// the RequireManualApproval workflow, and addCustomDomain, which reads
+// GetRequestUser to authorize the calling OrgAdmin. Unlike setupRoutes,
+// this must be mounted on the authenticated admin router (the one whose
+// middleware populates GetRequestUser), not on the public signup
+// subrouter, since GetRequestUser would otherwise always return nil.
+func (router *SignupRouter) setupAdminRoutes(s *mux.Router) {
+	s.HandleFunc("/domain", router.addCustomDomain).Methods("POST")
+	s.HandleFunc("/signups/pending", router.pendingSignups).Methods("GET")
+	s.HandleFunc("/signups/{id}/approve", router.approveSignup).Methods("POST")
+	s.HandleFunc("/signups/{id}/reject", router.rejectSignup).Methods("POST")
+}
+
 func (router *SignupRouter) signup(w http.ResponseWriter, r *http.Request) {
 	var m SignupRequest
 	if UnmarshalValidateBody(r, &m) != nil {
@@ -42,7 +112,30 @@ func (router *SignupRouter) signup(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	domain := strings.ToLower(m.Domain) + ".on.seatsurfing.de"
+	clientIP := getClientIP(r)
+	if router.RateLimiter != nil {
+		if !router.RateLimiter.Allow("ip:"+clientIP) || !router.DomainRateLimiter.Allow("domain:"+emailDomain(m.Email)) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+	if router.Captcha != nil {
+		ok, err := router.Captcha.Verify(m.CaptchaToken, clientIP)
+		if err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if router.EmailPolicy != nil && !router.EmailPolicy.IsAllowed(m.Email) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	domain := strings.ToLower(m.Domain) + "." + router.Config.BaseDomain
 	if !router.isDomainAvailable(domain) {
 		w.WriteHeader(http.StatusConflict)
 		return
@@ -51,11 +144,11 @@ func (router *SignupRouter) signup(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
-	if !router.isValidCountryCode(m.Country) {
+	if !isValidCountryCode(m.Country) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if !router.isValidLanguageCode(m.Language) {
+	if !isValidLanguageCode(m.Language) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -75,7 +168,7 @@ func (router *SignupRouter) signup(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if err := router.sendDoubleOptInMail(signup, router.getLanguage(signup.Language)); err != nil {
+	if err := router.sendDoubleOptInMail(signup, getLanguage(r, signup.Language)); err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -96,6 +189,177 @@ func (router *SignupRouter) confirm(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
+	router.completeOrQueueForApproval(w, r, e)
+}
+
+// completeOrQueueForApproval finishes a Signup whose email ownership has
+// just been proven (password confirm link or SSO callback): when
+// RequireManualApproval is set it only marks the Signup email-verified
+// and waits for a superadmin to approve it; otherwise it creates the
+// organization and admin user right away.
+func (router *SignupRouter) completeOrQueueForApproval(w http.ResponseWriter, r *http.Request, e *Signup) {
+	if router.Config.RequireManualApproval {
+		e.EmailVerified = true
+		if err := GetSignupRepository().Update(e); err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := router.finalizeAndDeliver(e, getLanguage(r, e.Language)); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeAndDeliver creates the organization/admin user for e, sends the
+// confirmation mail in the given language and removes the now-finished
+// Signup row. Callers pass the language explicitly rather than
+// negotiating it from the current request: on the confirm/SSO-callback
+// path that negotiation is against the signing-up visitor's own
+// Accept-Language, but on the superadmin approval path the request
+// belongs to the approving admin, not the signup, so e.Language must be
+// used as-is there.
+func (router *SignupRouter) finalizeAndDeliver(e *Signup, language string) error {
+	if err := router.finalizeSignup(e); err != nil {
+		return err
+	}
+	router.sendConfirmMail(e, language)
+	GetSignupRepository().Delete(e)
+	return nil
+}
+
+// resend re-sends the double opt-in mail for a pending signup under a
+// new confirm ID, so a lost confirmation link doesn't force the user to
+// start over. A signup already removed by the sweeper is not resent.
+func (router *SignupRouter) resend(w http.ResponseWriter, r *http.Request) {
+	var m ResendRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if router.RateLimiter != nil && !router.RateLimiter.Allow("resend:"+getClientIP(r)) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	e, err := GetSignupRepository().GetByEmail(m.Email)
+	if err != nil || e == nil {
+		// Do not leak whether a signup is pending for this email.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	fresh := &Signup{
+		Date:         time.Now(),
+		Email:        e.Email,
+		Password:     e.Password,
+		Firstname:    e.Firstname,
+		Lastname:     e.Lastname,
+		Organization: e.Organization,
+		Country:      e.Country,
+		Language:     e.Language,
+		Domain:       e.Domain,
+		AuthProvider: e.AuthProvider,
+		AuthSubject:  e.AuthSubject,
+	}
+	if err := GetSignupRepository().Create(fresh); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if err := router.sendDoubleOptInMail(fresh, getLanguage(r, fresh.Language)); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	GetSignupRepository().Delete(e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingSignups lists signups awaiting superadmin approval. The list is
+// only ever non-empty when RequireManualApproval is set, since
+// completeOrQueueForApproval (reached from confirm and the SSO callback)
+// is the only place that marks a Signup as email-verified.
+func (router *SignupRouter) pendingSignups(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	if user == nil || !user.SuperAdmin {
+		SendForbidden(w)
+		return
+	}
+	all, err := GetSignupRepository().GetAll()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	pending := make([]*Signup, 0)
+	for _, signup := range all {
+		if signup.EmailVerified {
+			pending = append(pending, signup)
+		}
+	}
+	SendJSON(w, pending)
+}
+
+// approveSignup lets a superadmin approve an email-verified signup,
+// creating its organization and initial admin user.
+func (router *SignupRouter) approveSignup(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	if user == nil || !user.SuperAdmin {
+		SendForbidden(w)
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetSignupRepository().GetOne(vars["id"])
+	if err != nil {
+		log.Println(err)
+		SendNotFound(w)
+		return
+	}
+	if !e.EmailVerified {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if !router.isDomainAvailable(e.Domain) {
+		GetSignupRepository().Delete(e)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err := router.finalizeAndDeliver(e, e.Language); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rejectSignup lets a superadmin discard a pending signup without
+// creating an organization for it.
+func (router *SignupRouter) rejectSignup(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	if user == nil || !user.SuperAdmin {
+		SendForbidden(w)
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetSignupRepository().GetOne(vars["id"])
+	if err != nil {
+		log.Println(err)
+		SendNotFound(w)
+		return
+	}
+	GetSignupRepository().Delete(e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeSignup creates the organization and its initial admin user for a
+// confirmed Signup. The admin user is created with a password if the
+// signup came from the password-based flow, or linked to the federated
+// identity captured during SSO signup otherwise.
+func (router *SignupRouter) finalizeSignup(e *Signup) error {
 	org := &Organization{
 		Name:             e.Organization,
 		ContactFirstname: e.Firstname,
@@ -105,30 +369,24 @@ func (router *SignupRouter) confirm(w http.ResponseWriter, r *http.Request) {
 		Country:          e.Country,
 	}
 	if err := GetOrganizationRepository().Create(org); err != nil {
-		log.Println(err)
-		SendInternalServerError(w)
-		return
+		return err
 	}
 	if err := GetOrganizationRepository().AddDomain(org, e.Domain, true); err != nil {
-		log.Println(err)
-		SendInternalServerError(w)
-		return
+		return err
 	}
 	user := &User{
 		Email:          "admin@" + e.Domain,
-		HashedPassword: NullString(e.Password),
 		OrganizationID: org.ID,
 		OrgAdmin:       true,
 		SuperAdmin:     false,
 	}
-	if err := GetUserRepository().Create(user); err != nil {
-		log.Println(err)
-		SendInternalServerError(w)
-		return
+	if e.AuthProvider != "" {
+		user.AuthProvider = e.AuthProvider
+		user.AuthSubject = e.AuthSubject
+	} else {
+		user.HashedPassword = NullString(e.Password)
 	}
-	router.sendConfirmMail(e, router.getLanguage(e.Language))
-	GetSignupRepository().Delete(e)
-	w.WriteHeader(http.StatusNoContent)
+	return GetUserRepository().Create(user)
 }
 
 func (router *SignupRouter) sendDoubleOptInMail(signup *Signup, language string) error {
@@ -137,7 +395,7 @@ func (router *SignupRouter) sendDoubleOptInMail(signup *Signup, language string)
 		"recipientEmail": signup.Email,
 		"confirmID":      signup.ID,
 	}
-	return sendEmail(signup.Email, "info@seatsurfing.de", EmailTemplateSignup, language, vars)
+	return sendEmail(signup.Email, router.Config.SenderAddress, EmailTemplateSignup, language, vars)
 }
 
 func (router *SignupRouter) sendConfirmMail(signup *Signup, language string) error {
@@ -146,35 +404,71 @@ func (router *SignupRouter) sendConfirmMail(signup *Signup, language string) err
 		"recipientEmail": signup.Email,
 		"username":       "admin@" + signup.Domain,
 	}
-	return sendEmail(signup.Email, "info@seatsurfing.de", EmailTemplateConfirm, language, vars)
+	return sendEmail(signup.Email, router.Config.SenderAddress, EmailTemplateConfirm, language, vars)
 }
 
-func (router *SignupRouter) getLanguage(language string) string {
-	lng := strings.ToLower(language)
-	switch lng {
-	case "de":
-		return lng
-	default:
-		return "en"
+// addCustomDomain lets an already-signed-up organization attach a custom
+// domain to their account. When RequireDNSVerification is set, ownership
+// must be proven via a TXT record challenge before the domain is added as
+// the organization's primary domain; otherwise it is added right away.
+func (router *SignupRouter) addCustomDomain(w http.ResponseWriter, r *http.Request) {
+	if !router.Config.AllowedCustomDomains {
+		SendNotFound(w)
+		return
 	}
-}
-
-func (router *SignupRouter) isValidCountryCode(isoCountryCode string) bool {
-	validCountryCodes := []string{"BE", "BG", "DK", "DE", "EE", "FJ", "FR", "GR", "IE", "IT", "HR", "LV", "LT", "LU", "MT", "NL", "AT", "PL", "PT", "RO", "SE", "SK", "SI", "ES", "CZ", "HU", "CY"}
-	cc := strings.ToUpper(isoCountryCode)
-	for _, s := range validCountryCodes {
-		if cc == s {
-			return true
-		}
+	user := GetRequestUser(r)
+	if user == nil || !user.OrgAdmin {
+		SendForbidden(w)
+		return
 	}
-	return false
+	var m CustomDomainRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	domain := strings.ToLower(m.Domain)
+	if !router.isDomainAvailable(domain) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	org, err := GetOrganizationRepository().GetOne(user.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	recordName := "_seatsurfing-challenge." + domain
+	recordValue := "seatsurfing-domain-verification=" + org.ID
+	if router.Config.RequireDNSVerification && !router.isDomainOwnershipVerified(recordName, recordValue) {
+		SendJSON(w, &CustomDomainChallengeResponse{
+			Verified:    false,
+			RecordName:  recordName,
+			RecordValue: recordValue,
+		})
+		return
+	}
+	if err := GetOrganizationRepository().AddDomain(org, domain, true); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	SendJSON(w, &CustomDomainChallengeResponse{
+		Verified:    true,
+		RecordName:  recordName,
+		RecordValue: recordValue,
+	})
 }
 
-func (router *SignupRouter) isValidLanguageCode(isoLanguageCode string) bool {
-	validLanguageCodes := []string{"de"}
-	lc := strings.ToLower(isoLanguageCode)
-	for _, s := range validLanguageCodes {
-		if lc == s {
+// isDomainOwnershipVerified looks up the TXT records for recordName and
+// reports whether one of them matches expectedValue. DNS lookup failures
+// (e.g. the record does not exist yet) are treated as "not verified".
+func (router *SignupRouter) isDomainOwnershipVerified(recordName, expectedValue string) bool {
+	records, err := net.LookupTXT(recordName)
+	if err != nil {
+		return false
+	}
+	for _, record := range records {
+		if record == expectedValue {
 			return true
 		}
 	}
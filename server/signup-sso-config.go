@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// SsoConfig describes one upstream OIDC / OAuth2 identity provider that
+// can be used to create the initial admin account during signup instead
+// of supplying a password. Provider is the URL path segment used in
+// /signup/sso/{provider}.
+type SsoConfig struct {
+	Provider     string   `json:"provider"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	AuthURL      string   `json:"authUrl"`
+	TokenURL     string   `json:"tokenUrl"`
+	UserInfoURL  string   `json:"userInfoUrl"`
+	RedirectURL  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// NewSsoServices reads the configured SSO providers from the
+// SIGNUP_SSO_SERVICES environment variable, a JSON array of SsoConfig.
+// Self-hosters that do not set it get no SSO providers and only the
+// password-based signup flow remains available.
+func NewSsoServices() []SsoConfig {
+	raw, ok := os.LookupEnv("SIGNUP_SSO_SERVICES")
+	if !ok || raw == "" {
+		return []SsoConfig{}
+	}
+	var services []SsoConfig
+	if err := json.Unmarshal([]byte(raw), &services); err != nil {
+		return []SsoConfig{}
+	}
+	return services
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SsoSignupRequest carries the organization details for a federated
+// signup. It mirrors SignupRequest but omits email, contact name,
+// password and CAPTCHA token; email and name are instead taken from the
+// upstream identity provider.
+type SsoSignupRequest struct {
+	HoneypotFirstname string `json:"firstname"`
+	HoneypotLastname  string `json:"lastname"`
+	Organization      string `json:"organization" validate:"required"`
+	Domain            string `json:"domain" validate:"required"`
+	Country           string `json:"country" validate:"required,len=2"`
+	Language          string `json:"language" validate:"required,len=2"`
+	AcceptTerms       bool   `json:"acceptTerms" validate:"required"`
+}
+
+type SsoAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
+
+// pendingSsoSignup tracks an in-flight OIDC authorization code request so
+// the callback can restore the organization details submitted when the
+// flow was started.
+type pendingSsoSignup struct {
+	Provider     string
+	Organization string
+	Domain       string
+	Country      string
+	Language     string
+	Created      time.Time
+}
+
+// ssoSignupStateTTL bounds how long an in-flight authorization request is
+// kept if the user never completes (or abandons) the upstream login, so
+// ssoSignups cannot grow without bound.
+const ssoSignupStateTTL = 10 * time.Minute
+
+var (
+	ssoSignupsMutex sync.Mutex
+	ssoSignups      = map[string]*pendingSsoSignup{}
+)
+
+// pruneExpiredSsoSignups removes entries older than ssoSignupStateTTL.
+// Must be called with ssoSignupsMutex held.
+func pruneExpiredSsoSignups() {
+	cutoff := time.Now().Add(-ssoSignupStateTTL)
+	for state, pending := range ssoSignups {
+		if pending.Created.Before(cutoff) {
+			delete(ssoSignups, state)
+		}
+	}
+}
+
+// startSsoSignup begins an OIDC authorization code flow for the given
+// provider and returns the URL the client should redirect the user to.
+func (router *SignupRouter) startSsoSignup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	config := router.getSsoConfig(vars["provider"])
+	if config == nil {
+		SendNotFound(w)
+		return
+	}
+	var m SsoSignupRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if m.HoneypotFirstname != "" || m.HoneypotLastname != "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	domain := strings.ToLower(m.Domain) + "." + router.Config.BaseDomain
+	if !router.isDomainAvailable(domain) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if !isValidCountryCode(m.Country) || !isValidLanguageCode(m.Language) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	state, err := generateRandomToken()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	ssoSignupsMutex.Lock()
+	pruneExpiredSsoSignups()
+	ssoSignups[state] = &pendingSsoSignup{
+		Provider:     config.Provider,
+		Organization: m.Organization,
+		Domain:       domain,
+		Country:      m.Country,
+		Language:     m.Language,
+		Created:      time.Now(),
+	}
+	ssoSignupsMutex.Unlock()
+	values := url.Values{}
+	values.Set("client_id", config.ClientID)
+	values.Set("redirect_uri", config.RedirectURL)
+	values.Set("response_type", "code")
+	values.Set("scope", strings.Join(config.Scopes, " "))
+	values.Set("state", state)
+	SendJSON(w, &SsoAuthorizationResponse{
+		AuthorizationURL: config.AuthURL + "?" + values.Encode(),
+	})
+}
+
+// ssoSignupCallback exchanges the authorization code for a token, reads
+// the upstream user's email/name claims and creates a Signup for them
+// with no password, linked instead to the federated identity.
+func (router *SignupRouter) ssoSignupCallback(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	config := router.getSsoConfig(vars["provider"])
+	if config == nil {
+		SendNotFound(w)
+		return
+	}
+	state := r.URL.Query().Get("state")
+	ssoSignupsMutex.Lock()
+	pending, ok := ssoSignups[state]
+	if ok {
+		delete(ssoSignups, state)
+	}
+	ssoSignupsMutex.Unlock()
+	if !ok || pending.Provider != config.Provider || time.Since(pending.Created) > ssoSignupStateTTL {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	accessToken, err := router.exchangeSsoCode(config, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	claims, err := router.fetchSsoUserInfo(config, accessToken)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	email := getClaimString(claims, "email", "preferred_username")
+	subject := getClaimString(claims, "sub")
+	if email == "" || subject == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !router.isEmailAvailable(email) || !router.isDomainAvailable(pending.Domain) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	firstname := getClaimString(claims, "given_name")
+	lastname := getClaimString(claims, "family_name")
+	if firstname == "" && lastname == "" {
+		firstname = getClaimString(claims, "name", "preferred_username")
+	}
+	signup := &Signup{
+		Date:         time.Now(),
+		Email:        email,
+		Firstname:    firstname,
+		Lastname:     lastname,
+		Organization: pending.Organization,
+		Country:      pending.Country,
+		Language:     pending.Language,
+		Domain:       pending.Domain,
+		AuthProvider: config.Provider,
+		AuthSubject:  subject,
+	}
+	if err := GetSignupRepository().Create(signup); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	router.completeOrQueueForApproval(w, r, signup)
+}
+
+func (router *SignupRouter) getSsoConfig(provider string) *SsoConfig {
+	for i := range router.SsoServices {
+		if router.SsoServices[i].Provider == provider {
+			return &router.SsoServices[i]
+		}
+	}
+	return nil
+}
+
+func (router *SignupRouter) exchangeSsoCode(config *SsoConfig, code string) (string, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", config.RedirectURL)
+	values.Set("client_id", config.ClientID)
+	values.Set("client_secret", config.ClientSecret)
+	res, err := http.PostForm(config.TokenURL, values)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.AccessToken, nil
+}
+
+func (router *SignupRouter) fetchSsoUserInfo(config *SsoConfig, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", config.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var claims map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// getClaimString returns the first non-empty string value found in
+// claims for any of keys, or "" if none match. Used to read claims that
+// different identity providers expose under different names (e.g.
+// "email" vs. "preferred_username"). Kept unexported and specific to SSO
+// claim lookups to avoid clashing with the equivalent UserInfoFields
+// helper used elsewhere in the app for the regular login flow.
+func getClaimString(claims map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := claims[key]; ok {
+			if s, ok := value.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func generateRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
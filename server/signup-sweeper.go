@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// StartSignupSweeper runs a loop, blocking until stop is closed, that
+// periodically deletes unconfirmed Signup rows older than ttl, so abandoned signups
+// don't accumulate forever. Signups already marked EmailVerified are
+// left alone: they are waiting on a superadmin approve/reject decision
+// under RequireManualApproval, not on the visitor confirming their
+// email, and sweeping them on the same TTL would silently undo that
+// approval gate. Intended to be started once from main alongside the
+// signup router, e.g. `go StartSignupSweeper(config.SignupTTL,
+// config.SweepInterval, stop)`. Closing stop terminates the goroutine.
+func StartSignupSweeper(ttl time.Duration, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sweepExpiredSignups(ttl)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func sweepExpiredSignups(ttl time.Duration) {
+	signups, err := GetSignupRepository().GetAll()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cutoff := time.Now().Add(-ttl)
+	for _, signup := range signups {
+		if signup.EmailVerified {
+			continue
+		}
+		if signup.Date.Before(cutoff) {
+			if err := GetSignupRepository().Delete(signup); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}